@@ -0,0 +1,42 @@
+package ntske
+
+import (
+	"crypto/cipher"
+
+	siv "github.com/secure-io/siv-go"
+)
+
+// AEADAlgorithm describes how to turn an exported key of a given length
+// into the cipher.AEAD negotiated during key exchange.
+type AEADAlgorithm struct {
+	KeyLen int
+	New    func(key []byte) (cipher.AEAD, error)
+}
+
+// AEADAlgorithms is the registry of AEAD algorithms known to this
+// package, keyed by their IANA AEAD Algorithms identifier. Additional
+// algorithms can be plugged in with RegisterAEAD without touching the
+// key exchange code.
+var AEADAlgorithms = map[uint16]AEADAlgorithm{
+	AES_SIV_CMAC_256: {KeyLen: 32, New: newAESSIVCMAC},
+	AES_SIV_CMAC_512: {KeyLen: 64, New: newAESSIVCMAC},
+	AES_128_GCM_SIV:  {KeyLen: 16, New: siv.NewGCM},
+	AES_256_GCM_SIV:  {KeyLen: 32, New: siv.NewGCM},
+}
+
+// RegisterAEAD adds or overrides an entry in AEADAlgorithms.
+func RegisterAEAD(id uint16, algo AEADAlgorithm) {
+	AEADAlgorithms[id] = algo
+}
+
+// LookupAEAD returns the AEADAlgorithm registered for id, if any.
+func LookupAEAD(id uint16) (AEADAlgorithm, bool) {
+	algo, ok := AEADAlgorithms[id]
+	return algo, ok
+}
+
+// newAESSIVCMAC builds an RFC 5297 AES-SIV-CMAC AEAD from the full
+// negotiated key; siv.NewCMAC does the K1/K2 split internally.
+func newAESSIVCMAC(key []byte) (cipher.AEAD, error) {
+	return siv.NewCMAC(key)
+}
@@ -0,0 +1,47 @@
+package ntske
+
+import (
+	"bytes"
+	"crypto/rand"
+	"testing"
+)
+
+// TestAEADRegistryRoundTrip exercises every AEADAlgorithm.New with a
+// key of its declared KeyLen, the length ExportKeys exports for that
+// algorithm, and then seals and opens a message
+// with the result. Constructing the cipher.AEAD is not enough: a
+// wrong call into the underlying library can still return a non-nil
+// AEAD whose Seal/Open disagree with its own API, so this also round
+// trips a message through each algorithm.
+func TestAEADRegistryRoundTrip(t *testing.T) {
+	for id, algo := range AEADAlgorithms {
+		key := make([]byte, algo.KeyLen)
+		_, err := rand.Read(key)
+		if err != nil {
+			t.Fatalf("generating key: %v", err)
+		}
+
+		aead, err := algo.New(key)
+		if err != nil {
+			t.Errorf("AEADAlgorithms[%#04x].New(%d-byte key): %v", id, algo.KeyLen, err)
+			continue
+		}
+		if aead == nil {
+			t.Errorf("AEADAlgorithms[%#04x].New returned a nil AEAD", id)
+			continue
+		}
+
+		nonce := make([]byte, aead.NonceSize())
+		plain := []byte("ntske aead round trip")
+
+		sealed := aead.Seal(nil, nonce, plain, nil)
+		opened, err := aead.Open(nil, nonce, sealed, nil)
+		if err != nil {
+			t.Errorf("AEADAlgorithms[%#04x]: Open: %v", id, err)
+			continue
+		}
+		if !bytes.Equal(opened, plain) {
+			t.Errorf("AEADAlgorithms[%#04x]: Open = %q, want %q", id, opened, plain)
+		}
+	}
+}
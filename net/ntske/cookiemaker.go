@@ -0,0 +1,131 @@
+package ntske
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/json"
+	"sync"
+	"time"
+)
+
+// cookieMasterKeyLen is the key length of the AEAD used to wrap
+// cookies, independent of the AEAD negotiated for the NTS-KE exchange
+// itself.
+const cookieMasterKeyLen = 32
+
+// plaintextCookie is the data encrypted into an opaque NTS cookie.
+type plaintextCookie struct {
+	Algo uint16
+	C2s  []byte
+	S2c  []byte
+}
+
+// cookieMasterKey is one generation of master key, timestamped so
+// aesSIVCookieMaker.rotate can prune it once it falls outside retain.
+type cookieMasterKey struct {
+	key       []byte
+	createdAt time.Time
+}
+
+// aesSIVCookieMaker is an in-memory CookieMaker that wraps cookies
+// under AES-SIV-CMAC-256 master keys, indexed by a rotating key id so
+// that cookies issued under a retired key can still be recognized.
+// Keys are pruned once they are older than retain, so a cookie handed
+// out more than retain ago may no longer be decryptable.
+type aesSIVCookieMaker struct {
+	mu        sync.RWMutex
+	keys      map[uint32]cookieMasterKey
+	currentID uint32
+	retain    time.Duration
+
+	stop      chan struct{}
+	closeOnce sync.Once
+}
+
+// NewAESSIVCookieMaker returns a CookieMaker that generates a fresh
+// AES-SIV-CMAC-256 master key every rotateEvery, keeping each prior
+// key around for 2*rotateEvery so cookies issued under it remain
+// decryptable for at least one more rotation before being pruned.
+func NewAESSIVCookieMaker(rotateEvery time.Duration) (*aesSIVCookieMaker, error) {
+	m := &aesSIVCookieMaker{
+		keys:   make(map[uint32]cookieMasterKey),
+		stop:   make(chan struct{}),
+		retain: 2 * rotateEvery,
+	}
+
+	if err := m.rotate(); err != nil {
+		return nil, err
+	}
+
+	go m.rotateLoop(rotateEvery)
+
+	return m, nil
+}
+
+func (m *aesSIVCookieMaker) rotate() error {
+	key := make([]byte, cookieMasterKeyLen)
+	_, err := rand.Read(key)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.currentID++
+	m.keys[m.currentID] = cookieMasterKey{key: key, createdAt: now}
+	for id, k := range m.keys {
+		if now.Sub(k.createdAt) > m.retain {
+			delete(m.keys, id)
+		}
+	}
+	return nil
+}
+
+func (m *aesSIVCookieMaker) rotateLoop(every time.Duration) {
+	ticker := time.NewTicker(every)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			m.rotate()
+		case <-m.stop:
+			return
+		}
+	}
+}
+
+// Close stops the key rotation goroutine.
+func (m *aesSIVCookieMaker) Close() {
+	m.closeOnce.Do(func() { close(m.stop) })
+}
+
+func (m *aesSIVCookieMaker) MakeCookie(algo uint16, c2s, s2c []byte) ([]byte, error) {
+	m.mu.RLock()
+	keyID := m.currentID
+	key := m.keys[keyID].key
+	m.mu.RUnlock()
+
+	aead, err := newAESSIVCMAC(key)
+	if err != nil {
+		return nil, err
+	}
+
+	plain, err := json.Marshal(plaintextCookie{Algo: algo, C2s: c2s, S2c: s2c})
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	_, err = rand.Read(nonce)
+	if err != nil {
+		return nil, err
+	}
+
+	out := make([]byte, 4, 4+len(nonce)+len(plain)+aead.Overhead())
+	binary.BigEndian.PutUint32(out, keyID)
+	out = append(out, nonce...)
+	out = aead.Seal(out, nonce, plain, nil)
+	return out, nil
+}
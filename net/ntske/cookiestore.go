@@ -0,0 +1,272 @@
+package ntske
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"go.uber.org/zap"
+)
+
+// CookieStore persists NTS cookies and session keys across process
+// restarts, so a client doesn't need to repeat the NTS-KE handshake
+// (and burn a fresh batch of cookies) on every run.
+type CookieStore interface {
+	// Load returns the cached Data for server.
+	Load(server string) (*Data, error)
+
+	// Save persists d as the cache for server, replacing whatever was
+	// cached before.
+	Save(server string, d *Data) error
+
+	// Consume pops and returns one cookie from the cache for server,
+	// persisting the remainder.
+	Consume(server string) ([]byte, error)
+}
+
+// FileCookieStore is a CookieStore backed by one JSON file per server
+// under Dir, written atomically via write-to-temp-then-rename, with
+// its keys base64-encoded.
+type FileCookieStore struct {
+	Dir string
+}
+
+// NewFileCookieStore returns a FileCookieStore rooted at dir, creating
+// it if necessary.
+func NewFileCookieStore(dir string) (*FileCookieStore, error) {
+	err := os.MkdirAll(dir, 0o700)
+	if err != nil {
+		return nil, err
+	}
+	return &FileCookieStore{Dir: dir}, nil
+}
+
+type fileCookieData struct {
+	C2sKey  string   `json:"c2s_key"`
+	S2cKey  string   `json:"s2c_key"`
+	Server  string   `json:"server"`
+	Port    uint16   `json:"port"`
+	Algo    uint16   `json:"algo"`
+	Cookies []string `json:"cookies"`
+}
+
+func (s *FileCookieStore) path(server string) string {
+	r := strings.NewReplacer("/", "_", ":", "_", "\\", "_")
+	return filepath.Join(s.Dir, r.Replace(server)+".json")
+}
+
+func (s *FileCookieStore) Load(server string) (*Data, error) {
+	unlock, err := lockPath(s.path(server) + ".lock")
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	return s.load(server)
+}
+
+func (s *FileCookieStore) load(server string) (*Data, error) {
+	b, err := os.ReadFile(s.path(server))
+	if err != nil {
+		return nil, err
+	}
+
+	var fcd fileCookieData
+	err = json.Unmarshal(b, &fcd)
+	if err != nil {
+		return nil, err
+	}
+
+	return fcd.toData()
+}
+
+func (s *FileCookieStore) Save(server string, d *Data) error {
+	unlock, err := lockPath(s.path(server) + ".lock")
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	return s.save(server, d)
+}
+
+func (s *FileCookieStore) save(server string, d *Data) error {
+	b, err := json.MarshalIndent(newFileCookieData(d), "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return writeFileAtomic(s.Dir, s.path(server), b)
+}
+
+func (s *FileCookieStore) Consume(server string) ([]byte, error) {
+	unlock, err := lockPath(s.path(server) + ".lock")
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	d, err := s.load(server)
+	if err != nil {
+		return nil, err
+	}
+	if len(d.Cookie) == 0 {
+		return nil, fmt.Errorf("ntske: no cached cookies for %s", server)
+	}
+
+	cookie := d.Cookie[0]
+	d.Cookie = d.Cookie[1:]
+
+	err = s.save(server, d)
+	if err != nil {
+		return nil, err
+	}
+
+	return cookie, nil
+}
+
+func newFileCookieData(d *Data) fileCookieData {
+	fcd := fileCookieData{
+		C2sKey: base64.StdEncoding.EncodeToString(d.C2sKey),
+		S2cKey: base64.StdEncoding.EncodeToString(d.S2cKey),
+		Server: d.Server,
+		Port:   d.Port,
+		Algo:   d.Algo,
+	}
+	for _, c := range d.Cookie {
+		fcd.Cookies = append(fcd.Cookies, base64.StdEncoding.EncodeToString(c))
+	}
+	return fcd
+}
+
+func (fcd fileCookieData) toData() (*Data, error) {
+	d := &Data{
+		Server: fcd.Server,
+		Port:   fcd.Port,
+		Algo:   fcd.Algo,
+	}
+
+	var err error
+	d.C2sKey, err = base64.StdEncoding.DecodeString(fcd.C2sKey)
+	if err != nil {
+		return nil, err
+	}
+	d.S2cKey, err = base64.StdEncoding.DecodeString(fcd.S2cKey)
+	if err != nil {
+		return nil, err
+	}
+	for _, c := range fcd.Cookies {
+		cookie, err := base64.StdEncoding.DecodeString(c)
+		if err != nil {
+			return nil, err
+		}
+		d.Cookie = append(d.Cookie, cookie)
+	}
+
+	return d, nil
+}
+
+// writeFileAtomic writes b to path by writing it to a temporary file
+// in dir and renaming it into place, then fsyncs dir so the rename is
+// itself durable.
+func writeFileAtomic(dir, path string, b []byte) error {
+	tmp, err := os.CreateTemp(dir, ".tmp-*")
+	if err != nil {
+		return err
+	}
+	tmpName := tmp.Name()
+
+	_, err = tmp.Write(b)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	closeErr := tmp.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmpName)
+		return err
+	}
+
+	err = os.Rename(tmpName, path)
+	if err != nil {
+		os.Remove(tmpName)
+		return err
+	}
+
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+
+	return d.Sync()
+}
+
+// EnsureCookies makes sure store holds at least min cookies for
+// server, performing a fresh NTS-KE handshake over tlsCfg only when
+// the cache falls short, and persisting whatever cookies the
+// handshake yields.
+func EnsureCookies(
+	ctx context.Context, server string, min int, store CookieStore, tlsCfg *tls.Config) error {
+	if d, err := store.Load(server); err == nil && len(d.Cookie) >= min {
+		return nil
+	}
+
+	type result struct {
+		data Data
+		err  error
+	}
+	ch := make(chan result, 1)
+	go func() {
+		hostport := server
+		if _, _, err := net.SplitHostPort(server); err != nil {
+			hostport = net.JoinHostPort(server, strconv.Itoa(DEFAULT_NTSKE_PORT))
+		}
+
+		conn, data, err := ConnectTCP(hostport, tlsCfg)
+		if err != nil {
+			ch <- result{err: err}
+			return
+		}
+		defer conn.Close()
+
+		err = ExchangeTCP(zap.NewNop(), conn, &data)
+		if err != nil {
+			ch <- result{err: err}
+			return
+		}
+
+		err = ExportKeys(conn.ConnectionState(), &data)
+		ch <- result{data: data, err: err}
+	}()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case res := <-ch:
+		if res.err != nil {
+			return res.err
+		}
+		return store.Save(server, &res.data)
+	}
+}
+
+// RotateCookie appends a freshly received NTS cookie, as handed back
+// in an NTP response, to the cache for server.
+func RotateCookie(store CookieStore, server string, cookie []byte) error {
+	d, err := store.Load(server)
+	if err != nil {
+		d = &Data{Server: server}
+	}
+	d.Cookie = append(d.Cookie, cookie)
+	return store.Save(server, d)
+}
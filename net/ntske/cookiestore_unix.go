@@ -0,0 +1,29 @@
+//go:build !windows
+
+package ntske
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// lockPath acquires an exclusive flock on path, creating it if
+// necessary, and returns a function that releases it.
+func lockPath(path string) (func(), error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0o600)
+	if err != nil {
+		return nil, err
+	}
+
+	err = unix.Flock(int(f.Fd()), unix.LOCK_EX)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	return func() {
+		unix.Flock(int(f.Fd()), unix.LOCK_UN)
+		f.Close()
+	}, nil
+}
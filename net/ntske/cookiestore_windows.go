@@ -0,0 +1,32 @@
+//go:build windows
+
+package ntske
+
+import (
+	"os"
+
+	"golang.org/x/sys/windows"
+)
+
+// lockPath acquires an exclusive LockFileEx lock on path, creating it
+// if necessary, and returns a function that releases it.
+func lockPath(path string) (func(), error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0o600)
+	if err != nil {
+		return nil, err
+	}
+
+	ol := new(windows.Overlapped)
+	err = windows.LockFileEx(
+		windows.Handle(f.Fd()), windows.LOCKFILE_EXCLUSIVE_LOCK, 0, 1, 0, ol)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	return func() {
+		ulOl := new(windows.Overlapped)
+		windows.UnlockFileEx(windows.Handle(f.Fd()), 0, 1, 0, ulOl)
+		f.Close()
+	}, nil
+}
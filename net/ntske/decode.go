@@ -0,0 +1,119 @@
+package ntske
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Defaults for Decoder limits.
+const (
+	DefaultMaxRecordBytes  = 16 * 1024
+	DefaultMaxMessageBytes = 64 * 1024
+	DefaultMaxCookies      = 8
+)
+
+// recordHdrSize is the wire size of a RecordHdr.
+const recordHdrSize = 4
+
+var (
+	// ErrRecordTooLarge is returned when a record's BodyLen exceeds
+	// Decoder.MaxRecordBytes.
+	ErrRecordTooLarge = errors.New("ntske: record exceeds MaxRecordBytes")
+
+	// ErrMessageTooLarge is returned when the records read so far
+	// exceed Decoder.MaxMessageBytes.
+	ErrMessageTooLarge = errors.New("ntske: message exceeds MaxMessageBytes")
+
+	// ErrTooManyCookies is returned when a message carries more
+	// Cookie records than Decoder.MaxCookies.
+	ErrTooManyCookies = errors.New("ntske: too many cookie records")
+
+	// ErrTruncated is returned when a record header or body could
+	// not be read in full, and by Read and ReadRequest when a record's
+	// body does not hold the fixed-size fields its type carries.
+	ErrTruncated = errors.New("ntske: truncated record")
+
+	// ErrUnknownCriticalRecord is returned by callers of NextRecord
+	// when they encounter a record type they do not recognize with
+	// the critical bit set.
+	ErrUnknownCriticalRecord = errors.New("ntske: unknown record type with critical bit set")
+)
+
+// RecordType returns the record type with the critical bit cleared.
+func (h RecordHdr) RecordType() uint16 { return h.Type &^ (1 << 15) }
+
+// Critical reports whether the critical bit is set on h.
+func (h RecordHdr) Critical() bool { return hasBit(h.Type, 15) }
+
+// Decoder reads a stream of NTS-KE records, bounding allocations by
+// MaxRecordBytes/MaxMessageBytes/MaxCookies instead of trusting a
+// peer's BodyLen unconditionally.
+type Decoder struct {
+	r io.Reader
+
+	// MaxRecordBytes bounds the BodyLen of any single record.
+	MaxRecordBytes int
+
+	// MaxMessageBytes bounds the sum of header and body bytes read
+	// over the Decoder's lifetime.
+	MaxMessageBytes int
+
+	// MaxCookies bounds the number of Cookie records read over the
+	// Decoder's lifetime.
+	MaxCookies int
+
+	totalRead int
+	cookies   int
+}
+
+// NewDecoder returns a Decoder reading from r with the default limits.
+func NewDecoder(r io.Reader) *Decoder {
+	return &Decoder{
+		r:               r,
+		MaxRecordBytes:  DefaultMaxRecordBytes,
+		MaxMessageBytes: DefaultMaxMessageBytes,
+		MaxCookies:      DefaultMaxCookies,
+	}
+}
+
+// NextRecord reads and returns the next record's header and body. It
+// returns io.EOF once the underlying reader is exhausted between
+// records, or one of the Err* sentinels above if a limit is exceeded
+// or the peer sent a malformed record.
+func (d *Decoder) NextRecord() (RecordHdr, []byte, error) {
+	var hdr RecordHdr
+	err := binary.Read(d.r, binary.BigEndian, &hdr)
+	if err != nil {
+		if err == io.EOF {
+			return hdr, nil, io.EOF
+		}
+		return hdr, nil, fmt.Errorf("%w: %s", ErrTruncated, err)
+	}
+
+	bodyLen := int(hdr.BodyLen)
+	if bodyLen > d.MaxRecordBytes {
+		return hdr, nil, ErrRecordTooLarge
+	}
+
+	d.totalRead += recordHdrSize + bodyLen
+	if d.totalRead > d.MaxMessageBytes {
+		return hdr, nil, ErrMessageTooLarge
+	}
+
+	body := make([]byte, bodyLen)
+	_, err = io.ReadFull(d.r, body)
+	if err != nil {
+		return hdr, nil, fmt.Errorf("%w: %s", ErrTruncated, err)
+	}
+
+	if hdr.RecordType() == RecCookie {
+		d.cookies++
+		if d.cookies > d.MaxCookies {
+			return hdr, nil, ErrTooManyCookies
+		}
+	}
+
+	return hdr, body, nil
+}
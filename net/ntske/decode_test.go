@@ -0,0 +1,115 @@
+package ntske
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"testing"
+)
+
+func packRecord(t *testing.T, typ uint16, critical bool, body []byte) []byte {
+	t.Helper()
+
+	hdr := RecordHdr{Type: typ, BodyLen: uint16(len(body))}
+	if critical {
+		hdr.Type = setBit(hdr.Type, 15)
+	}
+
+	buf := new(bytes.Buffer)
+	err := binary.Write(buf, binary.BigEndian, hdr)
+	if err != nil {
+		t.Fatalf("packing header: %v", err)
+	}
+	buf.Write(body)
+
+	return buf.Bytes()
+}
+
+func TestDecoderNextRecord(t *testing.T) {
+	body := []byte{0x00, 0x0f}
+	raw := packRecord(t, RecAead, true, body)
+
+	dec := NewDecoder(bytes.NewReader(raw))
+	hdr, got, err := dec.NextRecord()
+	if err != nil {
+		t.Fatalf("NextRecord: %v", err)
+	}
+	if hdr.RecordType() != RecAead || !hdr.Critical() {
+		t.Fatalf("header = %+v, want type %v critical", hdr, RecAead)
+	}
+	if !bytes.Equal(got, body) {
+		t.Fatalf("body = %x, want %x", got, body)
+	}
+}
+
+func TestDecoderRecordTooLarge(t *testing.T) {
+	raw := packRecord(t, RecCookie, false, make([]byte, 32))
+
+	dec := NewDecoder(bytes.NewReader(raw))
+	dec.MaxRecordBytes = 16
+
+	_, _, err := dec.NextRecord()
+	if !errors.Is(err, ErrRecordTooLarge) {
+		t.Fatalf("err = %v, want ErrRecordTooLarge", err)
+	}
+}
+
+func TestDecoderMessageTooLarge(t *testing.T) {
+	var raw []byte
+	raw = append(raw, packRecord(t, RecCookie, false, make([]byte, 16))...)
+	raw = append(raw, packRecord(t, RecCookie, false, make([]byte, 16))...)
+
+	dec := NewDecoder(bytes.NewReader(raw))
+	dec.MaxMessageBytes = 24
+
+	if _, _, err := dec.NextRecord(); err != nil {
+		t.Fatalf("first NextRecord: %v", err)
+	}
+
+	_, _, err := dec.NextRecord()
+	if !errors.Is(err, ErrMessageTooLarge) {
+		t.Fatalf("err = %v, want ErrMessageTooLarge", err)
+	}
+}
+
+func TestDecoderTooManyCookies(t *testing.T) {
+	var raw []byte
+	for i := 0; i < 3; i++ {
+		raw = append(raw, packRecord(t, RecCookie, false, []byte("c"))...)
+	}
+
+	dec := NewDecoder(bytes.NewReader(raw))
+	dec.MaxCookies = 2
+
+	for i := 0; i < 2; i++ {
+		if _, _, err := dec.NextRecord(); err != nil {
+			t.Fatalf("NextRecord %d: %v", i, err)
+		}
+	}
+
+	_, _, err := dec.NextRecord()
+	if !errors.Is(err, ErrTooManyCookies) {
+		t.Fatalf("err = %v, want ErrTooManyCookies", err)
+	}
+}
+
+func TestDecoderTruncated(t *testing.T) {
+	raw := packRecord(t, RecCookie, false, make([]byte, 8))
+
+	dec := NewDecoder(bytes.NewReader(raw[:len(raw)-4]))
+
+	_, _, err := dec.NextRecord()
+	if !errors.Is(err, ErrTruncated) {
+		t.Fatalf("err = %v, want ErrTruncated", err)
+	}
+}
+
+func TestDecoderEOF(t *testing.T) {
+	dec := NewDecoder(bytes.NewReader(nil))
+
+	_, _, err := dec.NextRecord()
+	if !errors.Is(err, io.EOF) {
+		t.Fatalf("err = %v, want io.EOF", err)
+	}
+}
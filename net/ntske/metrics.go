@@ -0,0 +1,98 @@
+package ntske
+
+// Counter is a monotonically increasing metric, optionally broken
+// down by a fixed set of label values.
+type Counter interface {
+	Inc(labels ...string)
+	Add(delta float64, labels ...string)
+}
+
+// Histogram observes a distribution of values, such as handshake
+// latency in seconds or cookies received per exchange.
+type Histogram interface {
+	Observe(value float64, labels ...string)
+}
+
+// Metrics is the set of instruments ntske reports to. RegisterMetrics
+// installs the active implementation; until then, a no-op Metrics is
+// used so instrumentation is free when nobody is scraping.
+type Metrics interface {
+	// HandshakeTotal counts handshake attempts, labeled by result: "ok",
+	// "tls_error", "alpn_mismatch", "timeout", "no_mutual_aead" or
+	// "cookie_error".
+	HandshakeTotal() Counter
+
+	// HandshakeDuration observes handshake latency in seconds,
+	// labeled by the same result values as HandshakeTotal.
+	HandshakeDuration() Histogram
+
+	// CookiesReceived observes the number of cookies received per
+	// exchange.
+	CookiesReceived() Histogram
+
+	// RecordsReceived counts records received, labeled by record
+	// type name.
+	RecordsReceived() Counter
+
+	// UnknownCriticalRecords counts records with an unrecognized
+	// type and the critical bit set.
+	UnknownCriticalRecords() Counter
+
+	// KeyExportFailures counts ExportKeys calls that failed, e.g.
+	// because of an unrecognized AEAD algorithm.
+	KeyExportFailures() Counter
+}
+
+var metrics Metrics = noopMetrics{}
+
+// RegisterMetrics installs m as the Metrics implementation used by
+// this package, e.g. a ntskeprom.Metrics. Passing nil restores the
+// no-op default. Not safe to call while handshakes are in progress.
+func RegisterMetrics(m Metrics) {
+	if m == nil {
+		m = noopMetrics{}
+	}
+	metrics = m
+}
+
+type noopCounter struct{}
+
+func (noopCounter) Inc(...string)          {}
+func (noopCounter) Add(float64, ...string) {}
+
+type noopHistogram struct{}
+
+func (noopHistogram) Observe(float64, ...string) {}
+
+type noopMetrics struct{}
+
+func (noopMetrics) HandshakeTotal() Counter         { return noopCounter{} }
+func (noopMetrics) HandshakeDuration() Histogram    { return noopHistogram{} }
+func (noopMetrics) CookiesReceived() Histogram      { return noopHistogram{} }
+func (noopMetrics) RecordsReceived() Counter        { return noopCounter{} }
+func (noopMetrics) UnknownCriticalRecords() Counter { return noopCounter{} }
+func (noopMetrics) KeyExportFailures() Counter      { return noopCounter{} }
+
+// recordTypeName returns the RecordsReceived label for a record type.
+func recordTypeName(t uint16) string {
+	switch t {
+	case RecEom:
+		return "eom"
+	case RecNextproto:
+		return "nextproto"
+	case RecError:
+		return "error"
+	case RecWarning:
+		return "warning"
+	case RecAead:
+		return "aead"
+	case RecCookie:
+		return "cookie"
+	case RecServer:
+		return "server"
+	case RecPort:
+		return "port"
+	default:
+		return "unknown"
+	}
+}
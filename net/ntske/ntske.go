@@ -26,7 +26,6 @@ import (
 	"bytes"
 	"crypto/tls"
 	"encoding/binary"
-	"errors"
 	"fmt"
 	"net"
 	"strconv"
@@ -44,6 +43,11 @@ type Data struct {
 	Port   uint16
 	Cookie [][]byte
 	Algo   uint16
+
+	// AEADPreference is the client's prioritized list of AEAD
+	// algorithm identifiers to advertise during the exchange. If
+	// empty, defaultAEADPreference is used.
+	AEADPreference []uint16
 }
 
 // NTS-KE record types
@@ -59,13 +63,43 @@ const (
 )
 
 const (
-	AES_SIV_CMAC_256   = 0x0f
-	DEFAULT_NTSKE_PORT = 4460
-	DEFAULT_NTP_PORT   = 123
+	AES_SIV_CMAC_256        = 0x0f
+	AES_SIV_CMAC_512        = 0x11
+	AES_128_GCM_SIV         = 0x1e
+	AES_256_GCM_SIV         = 0x1f
+	DEFAULT_NTSKE_PORT      = 4460
+	DEFAULT_NTSKE_QUIC_PORT = 4460
+	DEFAULT_NTP_PORT        = 123
 )
 
 const alpn = "ntske/1"
 
+// defaultAEADPreference is advertised when Data.AEADPreference is unset.
+var defaultAEADPreference = []uint16{
+	AES_SIV_CMAC_256,
+	AES_SIV_CMAC_512,
+	AES_128_GCM_SIV,
+	AES_256_GCM_SIV,
+}
+
+// exportKeyDirection identifies which side of the exchange a key is
+// exported for. It makes up the low byte of the per-algorithm export
+// context, with the high 4 bytes carrying the negotiated AEAD id in
+// network byte order.
+type exportKeyDirection byte
+
+const (
+	c2sDirection exportKeyDirection = 0x00
+	s2cDirection exportKeyDirection = 0x01
+)
+
+func exportContext(algo uint16, direction exportKeyDirection) []byte {
+	ctx := make([]byte, 5)
+	binary.BigEndian.PutUint32(ctx[0:4], uint32(algo))
+	ctx[4] = byte(direction)
+	return ctx
+}
+
 // RecordHdr is the header on all records send in NTS-KE. The first
 // bit of the Type is the critical bit.
 type RecordHdr struct {
@@ -266,7 +300,7 @@ func (a Algorithm) pack(buf *bytes.Buffer) error {
 func NewTCPListener(listener net.Listener) (*tls.Conn, error) {
 	conn, err := listener.Accept()
 	if err != nil {
-		return nil, fmt.Errorf("Couldn't answer`")
+		return nil, fmt.Errorf("couldn't accept connection: %w", err)
 	}
 
 	tlsConn, ok := conn.(*tls.Conn)
@@ -274,11 +308,14 @@ func NewTCPListener(listener net.Listener) (*tls.Conn, error) {
 		return nil, fmt.Errorf("could not convert to tls connection")
 	}
 
-	//state := tlsConn.ConnectionState()
-	//if state.NegotiatedProtocol != alpn {
-	//	fmt.Println(state.NegotiatedProtocol)
-	//	return nil, fmt.Errorf("client not speaking ntske/1")
-	//}
+	if err := tlsConn.Handshake(); err != nil {
+		return nil, fmt.Errorf("TLS handshake failed: %w", err)
+	}
+
+	state := tlsConn.ConnectionState()
+	if state.NegotiatedProtocol != alpn {
+		return nil, fmt.Errorf("client not speaking %s", alpn)
+	}
 
 	return tlsConn, nil
 }
@@ -294,29 +331,45 @@ func ConnectTCP(hostport string, config *tls.Config) (*tls.Conn, Data, error) {
 		hostport = net.JoinHostPort(hostport, strconv.Itoa(DEFAULT_NTSKE_PORT))
 	}
 
+	start := time.Now()
 	conn, err := tls.DialWithDialer(&net.Dialer{
 		Timeout: time.Second * 5,
 	}, "tcp", hostport, config)
 	if err != nil {
+		result := "tls_error"
+		if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+			result = "timeout"
+		}
+		metrics.HandshakeTotal().Inc(result)
+		metrics.HandshakeDuration().Observe(time.Since(start).Seconds(), result)
 		return nil, Data{}, err
 	}
 
 	var data Data
 	data.Server, _, err = net.SplitHostPort(conn.RemoteAddr().String())
 	if err != nil {
+		metrics.HandshakeTotal().Inc("tls_error")
+		metrics.HandshakeDuration().Observe(time.Since(start).Seconds(), "tls_error")
 		return nil, Data{}, fmt.Errorf("unexpected remoteaddr issue: %s", err)
 	}
 	data.Port = DEFAULT_NTP_PORT
 
 	state := conn.ConnectionState()
 	if state.NegotiatedProtocol != alpn {
+		metrics.HandshakeTotal().Inc("alpn_mismatch")
+		metrics.HandshakeDuration().Observe(time.Since(start).Seconds(), "alpn_mismatch")
 		return nil, Data{}, fmt.Errorf("server not speaking ntske/1")
 	}
 
 	return conn, data, nil
 }
 
+// ExchangeTCP runs the client side of the NTS-KE record exchange over
+// an already TLS-handshaken conn, recording the outcome as a
+// HandshakeTotal/"ok" or HandshakeTotal/"tls_error" result.
 func ExchangeTCP(log *zap.Logger, conn *tls.Conn, data *Data) error {
+	start := time.Now()
+
 	reader := bufio.NewReader(conn)
 
 	var msg ExchangeMsg
@@ -325,8 +378,12 @@ func ExchangeTCP(log *zap.Logger, conn *tls.Conn, data *Data) error {
 	nextproto.NextProto = NTPv4
 	msg.AddRecord(nextproto)
 
+	preference := data.AEADPreference
+	if len(preference) == 0 {
+		preference = defaultAEADPreference
+	}
 	var algo Algorithm
-	algo.Algo = []uint16{AES_SIV_CMAC_256}
+	algo.Algo = preference
 	msg.AddRecord(algo)
 
 	var end End
@@ -334,125 +391,120 @@ func ExchangeTCP(log *zap.Logger, conn *tls.Conn, data *Data) error {
 
 	buf, err := msg.Pack()
 	if err != nil {
+		metrics.HandshakeTotal().Inc("tls_error")
+		metrics.HandshakeDuration().Observe(time.Since(start).Seconds(), "tls_error")
 		return err
 	}
 
 	_, err = conn.Write(buf.Bytes())
 	if err != nil {
+		metrics.HandshakeTotal().Inc("tls_error")
+		metrics.HandshakeDuration().Observe(time.Since(start).Seconds(), "tls_error")
 		return err
 	}
 
 	err = Read(log, reader, data)
 	if err != nil {
+		metrics.HandshakeTotal().Inc("tls_error")
+		metrics.HandshakeDuration().Observe(time.Since(start).Seconds(), "tls_error")
 		return err
 	}
 
+	metrics.CookiesReceived().Observe(float64(len(data.Cookie)))
+	metrics.HandshakeTotal().Inc("ok")
+	metrics.HandshakeDuration().Observe(time.Since(start).Seconds(), "ok")
+
 	return nil
 }
 
-// ExportKeys exports two extra sessions keys from the already
-// established NTS-KE connection for use with NTS.
+// ExportKeys exports two extra session keys from the already
+// established NTS-KE connection for use with NTS, using the key
+// length and export context appropriate for the negotiated AEAD
+// algorithm in data.Algo.
 func ExportKeys(cs tls.ConnectionState, data *Data) error {
+	aeadAlgo, ok := AEADAlgorithms[data.Algo]
+	if !ok {
+		metrics.KeyExportFailures().Inc()
+		return fmt.Errorf("unsupported AEAD algorithm %#04x", data.Algo)
+	}
+
 	label := "EXPORTER-network-time-security"
-	s2cContext := []byte{0x00, 0x00, 0x00, 0x0f, 0x01}
-	c2sContext := []byte{0x00, 0x00, 0x00, 0x0f, 0x00}
-	len := 32
 
 	var err error
-	data.S2cKey, err = cs.ExportKeyingMaterial(label, s2cContext, len)
+	data.S2cKey, err = cs.ExportKeyingMaterial(
+		label, exportContext(data.Algo, s2cDirection), aeadAlgo.KeyLen)
 	if err != nil {
+		metrics.KeyExportFailures().Inc()
 		return err
 	}
 
-	data.C2sKey, err = cs.ExportKeyingMaterial(label, c2sContext, len)
+	data.C2sKey, err = cs.ExportKeyingMaterial(
+		label, exportContext(data.Algo, c2sDirection), aeadAlgo.KeyLen)
 	if err != nil {
+		metrics.KeyExportFailures().Inc()
 		return err
 	}
 
 	return nil
 }
 
+// Read decodes a server's NTS-KE response from reader into data. It is
+// a thin wrapper around Decoder kept for backward compatibility.
 func Read(log *zap.Logger, reader *bufio.Reader, data *Data) error {
-	var msg RecordHdr
-	var critical bool
+	dec := NewDecoder(reader)
 
 	for {
-		err := binary.Read(reader, binary.BigEndian, &msg)
+		hdr, body, err := dec.NextRecord()
 		if err != nil {
 			return err
 		}
 
+		metrics.RecordsReceived().Inc(recordTypeName(hdr.RecordType()))
+
 		// C (Critical Bit): Determines the disposition of
 		// unrecognized Record Types. Implementations which
 		// receive a record with an unrecognized Record Type
 		// MUST ignore the record if the Critical Bit is 0 and
 		// MUST treat it as an error if the Critical Bit is 1.
-		if hasBit(msg.Type, 15) {
-			critical = true
-		} else {
-			critical = false
-		}
-
-		// Get rid of Critical bit.
-		msg.Type &^= (1 << 15)
-
-		switch msg.Type {
+		switch hdr.RecordType() {
 		case RecEom:
 			return nil
 
 		case RecNextproto:
-			var nextProto uint16
-			err := binary.Read(reader, binary.BigEndian, &nextProto)
-			if err != nil {
-				return errors.New("buffer overrun")
+			if len(body) < 2 {
+				return ErrTruncated
 			}
 
 		case RecAead:
-			var aead uint16
-			err := binary.Read(reader, binary.BigEndian, &aead)
-			if err != nil {
-				return errors.New("buffer overrun")
+			if len(body) < 2 {
+				return ErrTruncated
+			}
+			aead := binary.BigEndian.Uint16(body)
+			if _, ok := AEADAlgorithms[aead]; !ok {
+				return fmt.Errorf("unsupported AEAD algorithm %#04x", aead)
 			}
-
 			data.Algo = aead
 
 		case RecCookie:
-			cookie := make([]byte, msg.BodyLen)
-			_, err := reader.Read(cookie)
-			if err != nil {
-				return errors.New("buffer overrun")
-			}
-
-			data.Cookie = append(data.Cookie, cookie)
+			data.Cookie = append(data.Cookie, body)
 
 		case RecServer:
-			address := make([]byte, msg.BodyLen)
-
-			err := binary.Read(reader, binary.BigEndian, &address)
-			if err != nil {
-				return errors.New("buffer overrun")
-			}
-			data.Server = string(address)
+			data.Server = string(body)
 			// log.Debug("NTSKE", zap.String("negotiated NTP server", data.Server))
 
 		case RecPort:
-			err := binary.Read(reader, binary.BigEndian, &data.Port)
-			if err != nil {
-				return errors.New("buffer overrun")
+			if len(body) < 2 {
+				return ErrTruncated
 			}
+			data.Port = binary.BigEndian.Uint16(body)
 			// log.Debug("NTSKE", zap.Uint16("negotiated port", data.Port))
 
 		default:
-			if critical {
-				return fmt.Errorf("unknown record type %v with critical bit set", msg.Type)
-			}
-
-			// Swallow unknown record.
-			unknownMsg := make([]byte, msg.BodyLen)
-			err := binary.Read(reader, binary.BigEndian, &unknownMsg)
-			if err != nil {
-				return errors.New("buffer overrun")
+			if hdr.Critical() {
+				metrics.UnknownCriticalRecords().Inc()
+				return fmt.Errorf("%w: type %v", ErrUnknownCriticalRecord, hdr.RecordType())
 			}
+			// Swallow unknown, non-critical record.
 		}
 	}
 }
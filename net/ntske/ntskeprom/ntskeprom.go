@@ -0,0 +1,101 @@
+// Package ntskeprom is a Prometheus-backed implementation of
+// ntske.Metrics, kept out of the core ntske package so that importing
+// it does not pull Prometheus into programs that don't scrape it.
+package ntskeprom
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+
+	"example.com/scion-time/net/ntske"
+)
+
+// Metrics reports ntske handshake and record-processing activity as
+// Prometheus collectors. Register it once with ntske.RegisterMetrics.
+type Metrics struct {
+	handshakeTotal         *prometheus.CounterVec
+	handshakeDuration      *prometheus.HistogramVec
+	cookiesReceived        prometheus.Histogram
+	recordsReceived        *prometheus.CounterVec
+	unknownCriticalRecords prometheus.Counter
+	keyExportFailures      prometheus.Counter
+}
+
+// New creates a Metrics and registers its collectors with reg.
+func New(reg prometheus.Registerer) *Metrics {
+	m := &Metrics{
+		handshakeTotal: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "ntske",
+			Name:      "handshakes_total",
+			Help:      "NTS-KE handshakes, by result (ok, tls_error, alpn_mismatch, timeout, no_mutual_aead, cookie_error).",
+		}, []string{"result"}),
+		handshakeDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: "ntske",
+			Name:      "handshake_duration_seconds",
+			Help:      "NTS-KE handshake latency, by result.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"result"}),
+		cookiesReceived: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace: "ntske",
+			Name:      "cookies_received",
+			Help:      "Number of NTS cookies received per exchange.",
+			Buckets:   prometheus.LinearBuckets(0, 1, 9),
+		}),
+		recordsReceived: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "ntske",
+			Name:      "records_received_total",
+			Help:      "NTS-KE records received, by record type.",
+		}, []string{"type"}),
+		unknownCriticalRecords: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: "ntske",
+			Name:      "unknown_critical_records_total",
+			Help:      "NTS-KE records with an unrecognized type and the critical bit set.",
+		}),
+		keyExportFailures: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: "ntske",
+			Name:      "key_export_failures_total",
+			Help:      "ExportKeys calls that failed.",
+		}),
+	}
+
+	reg.MustRegister(
+		m.handshakeTotal,
+		m.handshakeDuration,
+		m.cookiesReceived,
+		m.recordsReceived,
+		m.unknownCriticalRecords,
+		m.keyExportFailures,
+	)
+
+	return m
+}
+
+func (m *Metrics) HandshakeTotal() ntske.Counter      { return counterVec{m.handshakeTotal} }
+func (m *Metrics) HandshakeDuration() ntske.Histogram { return histogramVec{m.handshakeDuration} }
+func (m *Metrics) CookiesReceived() ntske.Histogram   { return histogram{m.cookiesReceived} }
+func (m *Metrics) RecordsReceived() ntske.Counter     { return counterVec{m.recordsReceived} }
+func (m *Metrics) UnknownCriticalRecords() ntske.Counter {
+	return counter{m.unknownCriticalRecords}
+}
+func (m *Metrics) KeyExportFailures() ntske.Counter { return counter{m.keyExportFailures} }
+
+type counter struct{ c prometheus.Counter }
+
+func (c counter) Inc(...string)                  { c.c.Inc() }
+func (c counter) Add(delta float64, _ ...string) { c.c.Add(delta) }
+
+type counterVec struct{ v *prometheus.CounterVec }
+
+func (c counterVec) Inc(labels ...string) { c.v.WithLabelValues(labels...).Inc() }
+func (c counterVec) Add(delta float64, labels ...string) {
+	c.v.WithLabelValues(labels...).Add(delta)
+}
+
+type histogram struct{ h prometheus.Histogram }
+
+func (h histogram) Observe(value float64, _ ...string) { h.h.Observe(value) }
+
+type histogramVec struct{ v *prometheus.HistogramVec }
+
+func (h histogramVec) Observe(value float64, labels ...string) {
+	h.v.WithLabelValues(labels...).Observe(value)
+}
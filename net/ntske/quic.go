@@ -0,0 +1,101 @@
+package ntske
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/quic-go/quic-go"
+	"go.uber.org/zap"
+)
+
+// ConnectQUIC opens an NTS-KE connection over QUIC, the transport
+// alternative to ConnectTCP for clients on lossy or middlebox-heavy
+// networks. It negotiates ALPN ntske/1 on the given UDP hostport,
+// defaulting to DEFAULT_NTSKE_QUIC_PORT if hostport carries no port.
+func ConnectQUIC(hostport string, tlsConfig *tls.Config, quicConfig *quic.Config) (
+	quic.Connection, Data, error) {
+	tlsConfig.NextProtos = []string{alpn}
+
+	_, _, err := net.SplitHostPort(hostport)
+	if err != nil {
+		if !strings.Contains(err.Error(), "missing port in address") {
+			return nil, Data{}, err
+		}
+		hostport = net.JoinHostPort(hostport, strconv.Itoa(DEFAULT_NTSKE_QUIC_PORT))
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	conn, err := quic.DialAddr(ctx, hostport, tlsConfig, quicConfig)
+	if err != nil {
+		return nil, Data{}, err
+	}
+
+	var data Data
+	data.Server, _, err = net.SplitHostPort(conn.RemoteAddr().String())
+	if err != nil {
+		return nil, Data{}, fmt.Errorf("unexpected remoteaddr issue: %s", err)
+	}
+	data.Port = DEFAULT_NTP_PORT
+
+	if conn.ConnectionState().TLS.NegotiatedProtocol != alpn {
+		return nil, Data{}, fmt.Errorf("server not speaking %s", alpn)
+	}
+
+	return conn, data, nil
+}
+
+// ExchangeQUIC runs the client side of the NTS-KE exchange over a
+// single bidirectional QUIC stream of conn, populating data the same
+// way ExchangeTCP does and then exporting its session keys with
+// ExportKeys, so downstream NTS code stays transport-agnostic.
+func ExchangeQUIC(log *zap.Logger, conn quic.Connection, stream quic.Stream, data *Data) error {
+	reader := bufio.NewReader(stream)
+
+	var msg ExchangeMsg
+	var nextproto NextProto
+	nextproto.NextProto = NTPv4
+	msg.AddRecord(nextproto)
+
+	preference := data.AEADPreference
+	if len(preference) == 0 {
+		preference = defaultAEADPreference
+	}
+	var algo Algorithm
+	algo.Algo = preference
+	msg.AddRecord(algo)
+
+	var end End
+	msg.AddRecord(end)
+
+	buf, err := msg.Pack()
+	if err != nil {
+		return err
+	}
+
+	_, err = stream.Write(buf.Bytes())
+	if err != nil {
+		return err
+	}
+
+	// Half-close the write side so the server sees the request is
+	// complete; the stream remains readable for its response.
+	err = stream.Close()
+	if err != nil {
+		return err
+	}
+
+	err = Read(log, reader, data)
+	if err != nil {
+		return err
+	}
+
+	return ExportKeys(conn.ConnectionState().TLS, data)
+}
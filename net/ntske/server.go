@@ -0,0 +1,248 @@
+package ntske
+
+import (
+	"bufio"
+	"crypto/tls"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// defaultNumCookies is the number of NTS cookies handed to a client
+// per exchange when ServerConfig.NumCookies is unset.
+const defaultNumCookies = 8
+
+// handshakeTimeout and requestTimeout bound how long serveConn waits on
+// a single client for the TLS handshake and the NTS-KE request records,
+// respectively, so a slow or hostile peer cannot pin a goroutine and
+// socket open indefinitely.
+const (
+	handshakeTimeout = 5 * time.Second
+	requestTimeout   = 5 * time.Second
+)
+
+// ServerConfig controls how ServeTCP answers NTS-KE requests.
+type ServerConfig struct {
+	// NumCookies is the number of NTS cookies returned per exchange.
+	// Defaults to defaultNumCookies if zero.
+	NumCookies int
+
+	// CookieMaker produces the NTS cookies handed to clients.
+	CookieMaker CookieMaker
+
+	// Server and Port, if set, are sent to the client as the NTP
+	// server/port to use for the next protocol.
+	Server string
+	Port   uint16
+}
+
+// CookieMaker produces opaque NTS cookies encoding the negotiated AEAD
+// algorithm and session keys of an exchange, so that a later NTP
+// exchange can be authenticated without another key exchange.
+type CookieMaker interface {
+	MakeCookie(algo uint16, c2s, s2c []byte) ([]byte, error)
+}
+
+// Request is the NextProto/Algorithm portion of a client's NTS-KE
+// request, as read by ReadRequest.
+type Request struct {
+	NextProto  uint16
+	Algorithms []uint16
+}
+
+// ReadRequest reads a client's request records from reader, up to and
+// including the End-of-Message record.
+func ReadRequest(reader *bufio.Reader) (Request, error) {
+	var req Request
+	dec := NewDecoder(reader)
+
+	for {
+		hdr, body, err := dec.NextRecord()
+		if err != nil {
+			return req, err
+		}
+
+		metrics.RecordsReceived().Inc(recordTypeName(hdr.RecordType()))
+
+		switch hdr.RecordType() {
+		case RecEom:
+			return req, nil
+
+		case RecNextproto:
+			if len(body) < 2 {
+				return req, ErrTruncated
+			}
+			req.NextProto = binary.BigEndian.Uint16(body)
+
+		case RecAead:
+			if len(body)%2 != 0 {
+				return req, ErrTruncated
+			}
+			req.Algorithms = make([]uint16, len(body)/2)
+			for i := range req.Algorithms {
+				req.Algorithms[i] = binary.BigEndian.Uint16(body[i*2:])
+			}
+
+		default:
+			if hdr.Critical() {
+				metrics.UnknownCriticalRecords().Inc()
+				return req, fmt.Errorf("%w: type %v", ErrUnknownCriticalRecord, hdr.RecordType())
+			}
+			// Swallow unknown, non-critical record.
+		}
+	}
+}
+
+// chooseAEAD returns the first algorithm in client's preference order
+// that is also present in AEADAlgorithms.
+func chooseAEAD(client []uint16) (uint16, bool) {
+	for _, algo := range client {
+		if _, ok := AEADAlgorithms[algo]; ok {
+			return algo, true
+		}
+	}
+	return 0, false
+}
+
+// ServeTCP accepts and serves NTS-KE connections from listener until it
+// returns an error, handling each connection in its own goroutine.
+func ServeTCP(log *zap.Logger, listener net.Listener, cfg ServerConfig) error {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return err
+		}
+
+		go func(conn net.Conn) {
+			err := serveConn(conn, cfg)
+			if err != nil {
+				log.Info("NTS-KE exchange failed", zap.Error(err))
+			}
+		}(conn)
+	}
+}
+
+func serveConn(conn net.Conn, cfg ServerConfig) error {
+	defer conn.Close()
+
+	start := time.Now()
+
+	tlsConn, ok := conn.(*tls.Conn)
+	if !ok {
+		metrics.HandshakeTotal().Inc("tls_error")
+		metrics.HandshakeDuration().Observe(time.Since(start).Seconds(), "tls_error")
+		return fmt.Errorf("connection is not TLS")
+	}
+
+	if err := tlsConn.SetDeadline(time.Now().Add(handshakeTimeout)); err != nil {
+		return fmt.Errorf("setting handshake deadline: %w", err)
+	}
+
+	if err := tlsConn.Handshake(); err != nil {
+		result := "tls_error"
+		if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+			result = "timeout"
+		}
+		metrics.HandshakeTotal().Inc(result)
+		metrics.HandshakeDuration().Observe(time.Since(start).Seconds(), result)
+		return fmt.Errorf("TLS handshake failed: %w", err)
+	}
+
+	state := tlsConn.ConnectionState()
+	if state.NegotiatedProtocol != alpn {
+		metrics.HandshakeTotal().Inc("alpn_mismatch")
+		metrics.HandshakeDuration().Observe(time.Since(start).Seconds(), "alpn_mismatch")
+		return fmt.Errorf("client not speaking %s", alpn)
+	}
+
+	if err := tlsConn.SetDeadline(time.Now().Add(requestTimeout)); err != nil {
+		return fmt.Errorf("setting request deadline: %w", err)
+	}
+
+	req, err := ReadRequest(bufio.NewReader(tlsConn))
+	if err != nil {
+		result := "tls_error"
+		if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+			result = "timeout"
+		}
+		metrics.HandshakeTotal().Inc(result)
+		metrics.HandshakeDuration().Observe(time.Since(start).Seconds(), result)
+		return fmt.Errorf("reading request: %w", err)
+	}
+
+	algo, ok := chooseAEAD(req.Algorithms)
+	if !ok {
+		metrics.HandshakeTotal().Inc("no_mutual_aead")
+		metrics.HandshakeDuration().Observe(time.Since(start).Seconds(), "no_mutual_aead")
+		return fmt.Errorf("no mutually supported AEAD algorithm")
+	}
+
+	var data Data
+	data.Algo = algo
+	err = ExportKeys(state, &data)
+	if err != nil {
+		metrics.HandshakeTotal().Inc("tls_error")
+		metrics.HandshakeDuration().Observe(time.Since(start).Seconds(), "tls_error")
+		return fmt.Errorf("exporting keys: %w", err)
+	}
+
+	numCookies := cfg.NumCookies
+	if numCookies == 0 {
+		numCookies = defaultNumCookies
+	}
+
+	var msg ExchangeMsg
+
+	var nextproto NextProto
+	nextproto.NextProto = NTPv4
+	msg.AddRecord(nextproto)
+
+	var algoRec Algorithm
+	algoRec.Algo = []uint16{algo}
+	msg.AddRecord(algoRec)
+
+	if cfg.Server != "" {
+		msg.AddRecord(Server{Addr: []byte(cfg.Server)})
+	}
+	if cfg.Port != 0 {
+		msg.AddRecord(Port{Port: cfg.Port})
+	}
+
+	for i := 0; i < numCookies; i++ {
+		cookie, err := cfg.CookieMaker.MakeCookie(algo, data.C2sKey, data.S2cKey)
+		if err != nil {
+			metrics.HandshakeTotal().Inc("cookie_error")
+			metrics.HandshakeDuration().Observe(time.Since(start).Seconds(), "cookie_error")
+			return fmt.Errorf("making cookie: %w", err)
+		}
+		msg.AddRecord(Cookie{Cookie: cookie})
+	}
+
+	msg.AddRecord(End{})
+
+	buf, err := msg.Pack()
+	if err != nil {
+		metrics.HandshakeTotal().Inc("tls_error")
+		metrics.HandshakeDuration().Observe(time.Since(start).Seconds(), "tls_error")
+		return err
+	}
+
+	if err := tlsConn.SetWriteDeadline(time.Now().Add(requestTimeout)); err != nil {
+		return fmt.Errorf("setting response deadline: %w", err)
+	}
+
+	_, err = tlsConn.Write(buf.Bytes())
+	if err != nil {
+		metrics.HandshakeTotal().Inc("tls_error")
+		metrics.HandshakeDuration().Observe(time.Since(start).Seconds(), "tls_error")
+		return err
+	}
+
+	metrics.HandshakeTotal().Inc("ok")
+	metrics.HandshakeDuration().Observe(time.Since(start).Seconds(), "ok")
+
+	return nil
+}